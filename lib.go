@@ -2,74 +2,292 @@ package filterchain
 
 import (
 	"context"
+	"errors"
+	"runtime"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
 // Executer executes a filter.
 type Executer interface {
-	Execute(*Chain, *Store) error
+	Execute(ctx context.Context, chain *Chain, store *Store) error
+}
+
+// FilterFunc is the legacy filter signature, kept around so existing filters
+// built with NewFilter keep compiling after ctx was added to Executer.
+type FilterFunc func(chain *Chain, store *Store) error
+
+// inline adapts a FilterFunc to the Executer interface.
+type inline struct {
+	fn FilterFunc
+}
+
+func (f *inline) Execute(ctx context.Context, chain *Chain, store *Store) error {
+	return f.fn(chain, store)
+}
+
+// NewFilter wraps fn as an Executer. fn does not receive ctx directly; read
+// chain.Ctx inside fn if the filter needs to observe cancellation itself.
+func NewFilter(fn FilterFunc) Executer {
+	return &inline{fn: fn}
+}
+
+// named wraps an Executer with a human-readable name reported in the
+// FilterEvent published for it.
+type named struct {
+	name string
+	Executer
+}
+
+func (n *named) Name() string { return n.name }
+
+// NewNamedFilter wraps fn as an Executer named name, so events published via
+// Chain.Subscribe identify it by name instead of just its position.
+func NewNamedFilter(name string, fn FilterFunc) Executer {
+	return &named{name: name, Executer: &inline{fn: fn}}
+}
+
+// namer is implemented by filters constructed with NewNamedFilter.
+type namer interface {
+	Name() string
+}
+
+// filterName returns the name of e if it was built with NewNamedFilter, or
+// "" otherwise.
+func filterName(e Executer) string {
+	if n, ok := e.(namer); ok {
+		return n.Name()
+	}
+	return ""
 }
 
 // Store manages data for a filterchain.
 type Store struct {
-	// guards data
-	sync.RWMutex
+	// guards data; shared with every view of this store created via Scope
+	// or NewDebugStore so concurrent parallel filters stay safe no matter
+	// which view they were handed
+	*sync.RWMutex
 
-	// data store for filters in a chain
+	// data store for filters in a chain; shared with every view of this
+	// store
 	data map[string]interface{}
+
+	// prefix confines this view of the store to keys beginning with
+	// prefix; empty for the root store
+	prefix string
+
+	// logger, if set, is notified of every Put/Get/Delete through this view
+	logger func(op, key string, value interface{})
 }
 
 // Put adds key/value pair to store.
 func (s *Store) Put(key string, value interface{}) {
 	s.Lock()
 	defer s.Unlock()
-	s.data[key] = value
+	s.data[s.prefix+key] = value
+	if s.logger != nil {
+		s.logger("Put", key, value)
+	}
 }
 
 // Get fetches value for the given key from store.
 func (s *Store) Get(key string) (interface{}, bool) {
 	s.RLock()
 	defer s.RUnlock()
-	var value, ok = s.data[key]
+	var value, ok = s.data[s.prefix+key]
+	if s.logger != nil {
+		s.logger("Get", key, value)
+	}
 	return value, ok
 }
 
+// Delete removes key from the store.
+func (s *Store) Delete(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.data, s.prefix+key)
+	if s.logger != nil {
+		s.logger("Delete", key, nil)
+	}
+}
+
+// Keys returns the keys visible through this view of the store, with any
+// scope prefix stripped off.
+func (s *Store) Keys() []string {
+	s.RLock()
+	defer s.RUnlock()
+	var keys = make([]string, 0, len(s.data))
+	for key := range s.data {
+		if strings.HasPrefix(key, s.prefix) {
+			keys = append(keys, strings.TrimPrefix(key, s.prefix))
+		}
+	}
+	return keys
+}
+
+// Snapshot returns a defensive copy of the data visible through this view
+// of the store, keyed without the scope prefix.
+func (s *Store) Snapshot() map[string]interface{} {
+	s.RLock()
+	defer s.RUnlock()
+	var snapshot = make(map[string]interface{}, len(s.data))
+	for key, value := range s.data {
+		if strings.HasPrefix(key, s.prefix) {
+			snapshot[strings.TrimPrefix(key, s.prefix)] = value
+		}
+	}
+	return snapshot
+}
+
+// scopeSep separates nested Scope prefixes in the underlying map key so
+// that one scope's prefix can never be a plain string prefix of a
+// sibling's (e.g. "a" and "ab" would otherwise collide on keys like
+// "a"+"bKey" == "ab"+"Key"). It's a control character that can't occur in
+// a prefix passed to Scope in practice. A view's Keys/Snapshot strip it off
+// along with that view's own prefix; a parent view lists its child scopes'
+// keys with their prefix and separator still in them.
+const scopeSep = "\x1f"
+
+// Scope returns a view of the store whose Put/Get/Delete/Keys/Snapshot are
+// transparently confined to keys under prefix. The returned store shares
+// the underlying map and mutex with s, so Get never observes keys outside
+// prefix while concurrent parallel filters remain safe.
+func (s *Store) Scope(prefix string) *Store {
+	return &Store{
+		RWMutex: s.RWMutex,
+		data:    s.data,
+		prefix:  s.prefix + prefix + scopeSep,
+		logger:  s.logger,
+	}
+}
+
+// NewDebugStore wraps s with a view that reports every Put/Get/Delete
+// through logger, for tracing what a chain is doing to its store without
+// modifying the filters that use it.
+func NewDebugStore(s *Store, logger func(op, key string, value interface{})) *Store {
+	return &Store{
+		RWMutex: s.RWMutex,
+		data:    s.data,
+		prefix:  s.prefix,
+		logger:  logger,
+	}
+}
+
+// EventKind identifies a point in a filter's, or the chain's, lifecycle
+// that can be observed via Chain.Subscribe.
+type EventKind int
+
+const (
+	// FilterStarted is published right before a filter's Execute is called.
+	FilterStarted EventKind = iota
+	// FilterCompleted is published after a filter's Execute returns nil, or,
+	// for a serial filter that calls Next, at the moment it calls Next.
+	FilterCompleted
+	// FilterErrored is published after a filter's Execute returns an error.
+	// A serial filter that called Next was already reported as completed,
+	// so no FilterErrored is published for it afterwards.
+	FilterErrored
+	// ChainCompleted is published once the chain has no more filters to run.
+	ChainCompleted
+)
+
+// FilterEvent describes a single lifecycle event published by a chain.
+type FilterEvent struct {
+	Kind     EventKind
+	Index    int
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
 // serialFilter executes sequentially.
 type serialFilter struct {
 	filter Executer
 }
 
-func (sf *serialFilter) Execute(chain *Chain, store *Store) error {
-	var err error
-	if err = sf.filter.Execute(chain, store); err != nil {
+func (sf *serialFilter) Execute(ctx context.Context, chain *Chain, store *Store) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	return nil
+	return sf.filter.Execute(ctx, chain, store)
+}
+
+// DefaultSerialThreshold is the SerialThreshold used by AddParallelFilters.
+// It preserves AddParallelFilters' existing promise that every group of 2
+// or more filters actually runs concurrently; callers who want small groups
+// promoted to inline execution instead must opt in explicitly through
+// AddParallelFiltersWithOptions with a higher SerialThreshold.
+const DefaultSerialThreshold = 1
+
+// ParallelOptions configures how a parallel filter group is run.
+type ParallelOptions struct {
+	// MaxConcurrency bounds how many filters in the group run at once.
+	// Defaults to runtime.GOMAXPROCS(0) when <= 0.
+	MaxConcurrency int
+	// SerialThreshold is the largest group size still run inline on the
+	// caller's goroutine, avoiding scheduler and errgroup overhead for
+	// tiny groups.
+	SerialThreshold int
 }
 
 // parallelFilter executes concurrently.
 type parallelFilter struct {
 	done    bool
 	filters []Executer
+	opts    ParallelOptions
 }
 
-func (pf *parallelFilter) Execute(chain *Chain, store *Store) error {
-	var g errgroup.Group
-	for _, filter := range pf.filters {
-		var filter = filter
-		g.Go(func() error {
-			var err error
-			if err = filter.Execute(chain, store); err != nil {
-				return err
-			}
-			return nil
-		})
+func (pf *parallelFilter) Execute(ctx context.Context, chain *Chain, store *Store) error {
+	var index = chain.pos - 1
+	var run = func(runCtx context.Context, filter Executer) error {
+		var name = filterName(filter)
+		var start = time.Now()
+		chain.publish(FilterEvent{Kind: FilterStarted, Index: index, Name: name})
+
+		var err = filter.Execute(runCtx, chain, store)
+		if err != nil {
+			chain.publish(FilterEvent{Kind: FilterErrored, Index: index, Name: name, Duration: time.Since(start), Err: err})
+			return err
+		}
+		chain.publish(FilterEvent{Kind: FilterCompleted, Index: index, Name: name, Duration: time.Since(start)})
+		return nil
 	}
 
 	var err error
-	if err = g.Wait(); err != nil {
+	if len(pf.filters) <= pf.opts.SerialThreshold {
+		for _, filter := range pf.filters {
+			if err = run(ctx, filter); err != nil {
+				break
+			}
+		}
+	} else {
+		var maxConcurrency = pf.opts.MaxConcurrency
+		if maxConcurrency <= 0 {
+			maxConcurrency = runtime.GOMAXPROCS(0)
+		}
+
+		var g, gCtx = errgroup.WithContext(ctx)
+		var sem = make(chan struct{}, maxConcurrency)
+	submit:
+		for _, filter := range pf.filters {
+			var filter = filter
+			select {
+			case sem <- struct{}{}:
+			case <-gCtx.Done():
+				break submit
+			}
+			g.Go(func() error {
+				defer func() { <-sem }()
+				return run(gCtx, filter)
+			})
+		}
+		err = g.Wait()
+	}
+
+	if err != nil {
 		return err
 	}
 
@@ -82,6 +300,141 @@ type Chain struct {
 	Ctx     context.Context
 	pos     int
 	filters []Executer
+
+	executing bool
+
+	// guards rollbacks
+	rollbackMu sync.Mutex
+	rollbacks  []func(*Store) error
+
+	// guards subs
+	subsMu sync.Mutex
+	subs   []*subscription
+
+	// guards filterStart/reported; tracks per-index event bookkeeping for
+	// serial filters so a filter's own duration and outcome exclude
+	// whatever it goes on to trigger via Next
+	eventMu     sync.Mutex
+	filterStart map[int]time.Time
+	reported    map[int]bool
+}
+
+// beginFilterEvent records the start time for the serial filter at index
+// and publishes FilterStarted for it.
+func (chain *Chain) beginFilterEvent(index int, name string) {
+	chain.eventMu.Lock()
+	if chain.filterStart == nil {
+		chain.filterStart = make(map[int]time.Time)
+		chain.reported = make(map[int]bool)
+	}
+	chain.filterStart[index] = time.Now()
+	chain.reported[index] = false
+	chain.eventMu.Unlock()
+
+	chain.publish(FilterEvent{Kind: FilterStarted, Index: index, Name: name})
+}
+
+// completeFilterEvent publishes FilterCompleted/FilterErrored for index
+// using err as the filter's own outcome, unless it was already reported by
+// reportFilterEventDone (because the filter called Next itself).
+func (chain *Chain) completeFilterEvent(index int, name string, err error) {
+	chain.eventMu.Lock()
+	var start, started = chain.filterStart[index]
+	var already = chain.reported[index]
+	chain.reported[index] = true
+	chain.eventMu.Unlock()
+
+	if !started || already {
+		return
+	}
+	chain.publishFilterOutcome(index, name, start, err)
+}
+
+// reportFilterEventDone publishes a serial filter's own FilterCompleted
+// event at the moment it calls Next, before Next recurses into whatever
+// comes next in the chain. Without this, a filter's published duration and
+// outcome would include the entire downstream chain it triggers.
+func (chain *Chain) reportFilterEventDone(index int, name string) {
+	chain.eventMu.Lock()
+	var start, started = chain.filterStart[index]
+	var already = chain.reported[index]
+	chain.reported[index] = true
+	chain.eventMu.Unlock()
+
+	if !started || already {
+		return
+	}
+	chain.publishFilterOutcome(index, name, start, nil)
+}
+
+func (chain *Chain) publishFilterOutcome(index int, name string, start time.Time, err error) {
+	if err != nil {
+		chain.publish(FilterEvent{Kind: FilterErrored, Index: index, Name: name, Duration: time.Since(start), Err: err})
+		return
+	}
+	chain.publish(FilterEvent{Kind: FilterCompleted, Index: index, Name: name, Duration: time.Since(start)})
+}
+
+// subscriberBufferSize bounds how many undelivered events a subscriber can
+// accumulate before publish starts dropping events for it.
+const subscriberBufferSize = 16
+
+// subscription is one listener registered via Chain.Subscribe.
+type subscription struct {
+	kind EventKind
+	ch   chan FilterEvent
+}
+
+// CancelFunc unsubscribes a listener registered via Chain.Subscribe. It is
+// safe to call more than once, and safe to call from inside the subscriber
+// goroutine itself.
+type CancelFunc func()
+
+// Subscribe registers interest in events of kind and returns a channel that
+// receives them, along with a CancelFunc to unsubscribe. Publishing never
+// blocks on a slow subscriber: once a subscriber's buffer is full, further
+// events for it are dropped rather than stalling the pipeline.
+func (chain *Chain) Subscribe(kind EventKind) (<-chan FilterEvent, CancelFunc) {
+	var sub = &subscription{kind: kind, ch: make(chan FilterEvent, subscriberBufferSize)}
+
+	chain.subsMu.Lock()
+	chain.subs = append(chain.subs, sub)
+	chain.subsMu.Unlock()
+
+	var once sync.Once
+	var cancel = func() {
+		once.Do(func() {
+			chain.subsMu.Lock()
+			defer chain.subsMu.Unlock()
+			for i, s := range chain.subs {
+				if s == sub {
+					chain.subs = append(chain.subs[:i], chain.subs[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+	return sub.ch, cancel
+}
+
+// publish sends event to every subscriber registered for its kind. A
+// subscriber with a full buffer has the event dropped for it instead of
+// blocking the caller.
+func (chain *Chain) publish(event FilterEvent) {
+	chain.subsMu.Lock()
+	var subs = make([]*subscription, len(chain.subs))
+	copy(subs, chain.subs)
+	chain.subsMu.Unlock()
+
+	for _, sub := range subs {
+		if sub.kind != event.Kind {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
 }
 
 // New creates a new chain & data store.
@@ -93,7 +446,52 @@ func New(ctx context.Context) (*Chain, *Store) {
 		Ctx:     ctx,
 		pos:     0,
 		filters: make([]Executer, 0),
-	}, &Store{data: make(map[string]interface{})}
+	}, &Store{RWMutex: &sync.RWMutex{}, data: make(map[string]interface{})}
+}
+
+// WithTimeout derives chain.Ctx with a timeout of d, storing the result back
+// onto the chain. The returned cancel func must be called once the chain is
+// done executing to release the timer.
+func (chain *Chain) WithTimeout(d time.Duration) (*Chain, context.CancelFunc) {
+	var ctx, cancel = context.WithTimeout(chain.Ctx, d)
+	chain.Ctx = ctx
+	return chain, cancel
+}
+
+// WithDeadline derives chain.Ctx with deadline t, storing the result back
+// onto the chain. The returned cancel func must be called once the chain is
+// done executing to release resources.
+func (chain *Chain) WithDeadline(t time.Time) (*Chain, context.CancelFunc) {
+	var ctx, cancel = context.WithDeadline(chain.Ctx, t)
+	chain.Ctx = ctx
+	return chain, cancel
+}
+
+// OnRollback registers a compensating action that undoes work done by the
+// calling filter. If a later filter (serial or parallel) returns an error,
+// actions registered so far are run in LIFO order before the error comes
+// back out of Chain.Execute.
+func (chain *Chain) OnRollback(fn func(*Store) error) {
+	chain.rollbackMu.Lock()
+	defer chain.rollbackMu.Unlock()
+	chain.rollbacks = append(chain.rollbacks, fn)
+}
+
+// rollback runs registered rollback actions in LIFO order, joining their
+// errors together.
+func (chain *Chain) rollback(store *Store) error {
+	chain.rollbackMu.Lock()
+	var actions = chain.rollbacks
+	chain.rollbacks = nil
+	chain.rollbackMu.Unlock()
+
+	var errs []error
+	for i := len(actions) - 1; i >= 0; i-- {
+		if err := actions[i](store); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // AddFilters adds a list of filters which are executed sequentially.
@@ -105,28 +503,71 @@ func (chain *Chain) AddFilters(filters ...Executer) *Chain {
 	return chain
 }
 
-// AddParallelFilters adds a list of filters which are executed concurrently.
-func (chain *Chain) AddParallelFilters(filters ...Executer) *Chain {
+// AddParallelFiltersWithOptions adds a list of filters executed concurrently
+// under opts. A group of opts.SerialThreshold filters or fewer runs inline
+// on the caller's goroutine; larger groups run on a worker pool bounded to
+// opts.MaxConcurrency.
+func (chain *Chain) AddParallelFiltersWithOptions(opts ParallelOptions, filters ...Executer) *Chain {
 	switch len(filters) {
 	case 0:
 		return chain
 	case 1:
 		return chain.AddFilters(filters[0])
 	default:
-		var pf = &parallelFilter{filters: filters, done: false}
+		var pf = &parallelFilter{filters: filters, opts: opts, done: false}
 		chain.filters = append(chain.filters, pf)
 		return chain
 	}
 }
 
+// AddParallelFilters adds a list of filters which are executed concurrently,
+// using sensible defaults for ParallelOptions. See
+// AddParallelFiltersWithOptions for a version that lets you tune those
+// defaults for large fan-out groups.
+func (chain *Chain) AddParallelFilters(filters ...Executer) *Chain {
+	return chain.AddParallelFiltersWithOptions(ParallelOptions{
+		MaxConcurrency:  runtime.GOMAXPROCS(0),
+		SerialThreshold: DefaultSerialThreshold,
+	}, filters...)
+}
+
 // Execute executes filters in the chain.
 func (chain *Chain) Execute(store *Store) error {
+	var root = !chain.executing
+	if root {
+		chain.executing = true
+		defer func() { chain.executing = false }()
+	}
+
 	var pos = chain.pos
-	if pos < len(chain.filters) {
-		chain.pos++
-		if err := chain.filters[pos].Execute(chain, store); err != nil {
-			return err
+	if pos >= len(chain.filters) {
+		chain.publish(FilterEvent{Kind: ChainCompleted})
+		return nil
+	}
+
+	chain.pos++
+	var filter = chain.filters[pos]
+	var sf, isSerial = filter.(*serialFilter)
+	var instrument = isSerial && chain.Ctx.Err() == nil
+	var name string
+	if instrument {
+		name = filterName(sf.filter)
+		chain.beginFilterEvent(pos, name)
+	}
+
+	var err = filter.Execute(chain.Ctx, chain, store)
+
+	if instrument {
+		chain.completeFilterEvent(pos, name, err)
+	}
+
+	if err != nil {
+		if root {
+			if rbErr := chain.rollback(store); rbErr != nil {
+				return errors.Join(err, rbErr)
+			}
 		}
+		return err
 	}
 
 	return nil
@@ -141,6 +582,9 @@ func (chain *Chain) Next(store *Store) error {
 			return chain.Execute(store)
 		}
 		return nil // one or more filters are remaining to be processed
+	case *serialFilter:
+		chain.reportFilterEventDone(pos, filterName(filter.filter))
+		return chain.Execute(store)
 	default:
 		return chain.Execute(store)
 	}
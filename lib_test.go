@@ -1,17 +1,19 @@
 package filterchain
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestWriteToStore(t *testing.T) {
-	var store = &Store{data: make(map[string]interface{})}
+	var store = &Store{RWMutex: &sync.RWMutex{}, data: make(map[string]interface{})}
 	store.Put("Key", "XA1")
 	assert.Len(t, store.data, 1)
 
@@ -20,7 +22,7 @@ func TestWriteToStore(t *testing.T) {
 }
 
 func TestFetchFromStore(t *testing.T) {
-	var store = &Store{data: make(map[string]interface{})}
+	var store = &Store{RWMutex: &sync.RWMutex{}, data: make(map[string]interface{})}
 	store.Put("Key", "XA1")
 
 	var iValue, ok = store.Get("Key")
@@ -34,7 +36,7 @@ func TestFetchFromStore(t *testing.T) {
 
 func TestStoreConcurrentWrites(t *testing.T) {
 	var concurrentWrites = func() {
-		var store = &Store{data: make(map[string]interface{})}
+		var store = &Store{RWMutex: &sync.RWMutex{}, data: make(map[string]interface{})}
 		var wg sync.WaitGroup
 
 		for i := 0; i < 500; i++ {
@@ -51,6 +53,81 @@ func TestStoreConcurrentWrites(t *testing.T) {
 	assert.NotPanics(t, concurrentWrites)
 }
 
+func TestStoreDeleteKeysAndSnapshot(t *testing.T) {
+	var store = &Store{RWMutex: &sync.RWMutex{}, data: make(map[string]interface{})}
+	store.Put("Key1", "XA1")
+	store.Put("Key2", "XA2")
+
+	assert.ElementsMatch(t, []string{"Key1", "Key2"}, store.Keys())
+	assert.Equal(t, map[string]interface{}{"Key1": "XA1", "Key2": "XA2"}, store.Snapshot())
+
+	store.Delete("Key1")
+	var _, ok = store.Get("Key1")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"Key2"}, store.Keys())
+}
+
+func TestStoreScopeIsolatesKeys(t *testing.T) {
+	var store = &Store{RWMutex: &sync.RWMutex{}, data: make(map[string]interface{})}
+	var scoped = store.Scope("ns1.")
+	scoped.Put("Key", "scoped-value")
+	store.Put("Key", "root-value")
+
+	var scopedValue, ok = scoped.Get("Key")
+	assert.True(t, ok)
+	assert.Equal(t, "scoped-value", scopedValue)
+
+	var rootValue interface{}
+	rootValue, ok = store.Get("Key")
+	assert.True(t, ok)
+	assert.Equal(t, "root-value", rootValue)
+
+	assert.Equal(t, []string{"Key"}, scoped.Keys())
+}
+
+func TestStoreScopeDoesNotCollideOnOverlappingPrefixes(t *testing.T) {
+	var store = &Store{RWMutex: &sync.RWMutex{}, data: make(map[string]interface{})}
+	store.Scope("a").Put("bKey", "a-scope-value")
+	store.Scope("ab").Put("Key", "ab-scope-value")
+
+	var value, ok = store.Scope("a").Get("bKey")
+	assert.True(t, ok)
+	assert.Equal(t, "a-scope-value", value)
+	assert.Equal(t, []string{"bKey"}, store.Scope("a").Keys())
+
+	value, ok = store.Scope("ab").Get("Key")
+	assert.True(t, ok)
+	assert.Equal(t, "ab-scope-value", value)
+	assert.Equal(t, []string{"Key"}, store.Scope("ab").Keys())
+
+	store.Scope("1").Put("0Key", "one-scope-value")
+	store.Scope("10").Put("Key", "ten-scope-value")
+
+	value, ok = store.Scope("1").Get("0Key")
+	assert.True(t, ok)
+	assert.Equal(t, "one-scope-value", value)
+
+	value, ok = store.Scope("10").Get("Key")
+	assert.True(t, ok)
+	assert.Equal(t, "ten-scope-value", value)
+}
+
+func TestNewDebugStoreTracesAccess(t *testing.T) {
+	var store = &Store{RWMutex: &sync.RWMutex{}, data: make(map[string]interface{})}
+	var ops []string
+	var debugged = NewDebugStore(store, func(op, key string, value interface{}) {
+		ops = append(ops, op+":"+key)
+	})
+
+	debugged.Put("Key", "XA1")
+	debugged.Get("Key")
+
+	assert.Equal(t, []string{"Put:Key", "Get:Key"}, ops)
+	var value, ok = store.Get("Key")
+	assert.True(t, ok)
+	assert.Equal(t, "XA1", value)
+}
+
 func TestCreateNewChain(t *testing.T) {
 	var chain, store = New(nil)
 	assert.NotNil(t, chain.Ctx)
@@ -69,7 +146,7 @@ func TestNewFilter(t *testing.T) {
 	var inlineFilterType *inline
 	assert.IsType(t, inlineFilterType, filter)
 
-	filter.Execute(nil, nil)
+	filter.Execute(context.Background(), nil, nil)
 	assert.Equal(t, 2, result)
 }
 
@@ -124,7 +201,7 @@ func TestSerialFilter(t *testing.T) {
 	assert.Len(t, chain.filters, 1)
 	var serialFilter, ok = chain.filters[0].(*serialFilter)
 	assert.True(t, ok)
-	var err = serialFilter.Execute(chain, store)
+	var err = serialFilter.Execute(context.Background(), chain, store)
 	assert.NoError(t, err)
 	var result int
 	var resultInterface interface{}
@@ -145,7 +222,7 @@ func TestSerialFilterError(t *testing.T) {
 	assert.Len(t, chain.filters, 1)
 	var serialFilter, ok = chain.filters[0].(*serialFilter)
 	assert.True(t, ok)
-	var err = serialFilter.Execute(chain, nil)
+	var err = serialFilter.Execute(context.Background(), chain, nil)
 	assert.EqualError(t, err, "filter execution failed")
 }
 
@@ -171,11 +248,220 @@ func TestParallelFilter(t *testing.T) {
 	chain.pos++ // signal chain that it's processing first filter
 	var parallelFilter, ok = chain.filters[0].(*parallelFilter)
 	assert.True(t, ok)
-	var err = parallelFilter.Execute(chain, nil)
+	var err = parallelFilter.Execute(context.Background(), chain, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, uint64(3), *result)
 }
 
+func TestSerialFilterCancelledContext(t *testing.T) {
+	var chain, _ = New(nil)
+	var called bool
+	var filter1 = NewFilter(func(chain *Chain, store *Store) error {
+		called = true
+		return nil
+	})
+
+	chain.AddFilters(filter1)
+	var serialFilter, ok = chain.filters[0].(*serialFilter)
+	assert.True(t, ok)
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	var err = serialFilter.Execute(ctx, chain, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, called)
+}
+
+// ctxAwareFilter is an Executer that observes the ctx passed to Execute
+// directly, unlike filters built with NewFilter.
+type ctxAwareFilter func(ctx context.Context, chain *Chain, store *Store) error
+
+func (f ctxAwareFilter) Execute(ctx context.Context, chain *Chain, store *Store) error {
+	return f(ctx, chain, store)
+}
+
+func TestParallelFilterAbortsSiblingsOnError(t *testing.T) {
+	var chain, _ = New(nil)
+	var filter1 = ctxAwareFilter(func(ctx context.Context, chain *Chain, store *Store) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	var filter2 = NewFilter(func(chain *Chain, store *Store) error {
+		return errors.New("filter execution failed")
+	})
+
+	chain.AddParallelFiltersWithOptions(ParallelOptions{MaxConcurrency: 2, SerialThreshold: 0}, filter1, filter2)
+	var parallelFilter, ok = chain.filters[0].(*parallelFilter)
+	assert.True(t, ok)
+	var err = parallelFilter.Execute(context.Background(), chain, nil)
+	assert.EqualError(t, err, "filter execution failed")
+}
+
+func TestParallelGroupAtOrBelowSerialThresholdRunsInline(t *testing.T) {
+	var chain, _ = New(nil)
+	var order []int
+	var filter1 = NewFilter(func(chain *Chain, store *Store) error {
+		time.Sleep(20 * time.Millisecond)
+		order = append(order, 1)
+		return nil
+	})
+	var filter2 = NewFilter(func(chain *Chain, store *Store) error {
+		order = append(order, 2)
+		return nil
+	})
+
+	chain.AddParallelFiltersWithOptions(ParallelOptions{SerialThreshold: 2}, filter1, filter2)
+	chain.pos++ // signal chain that it's processing first filter
+	var parallelFilter, ok = chain.filters[0].(*parallelFilter)
+	assert.True(t, ok)
+	var err = parallelFilter.Execute(context.Background(), chain, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, order)
+}
+
+func TestParallelGroupBoundsFanOutToMaxConcurrency(t *testing.T) {
+	var chain, _ = New(nil)
+	var current, peak int32
+	var filters = make([]Executer, 0, 5)
+	for i := 0; i < 5; i++ {
+		filters = append(filters, NewFilter(func(chain *Chain, store *Store) error {
+			var n = atomic.AddInt32(&current, 1)
+			for {
+				var p = atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&current, -1)
+			return nil
+		}))
+	}
+
+	chain.AddParallelFiltersWithOptions(ParallelOptions{MaxConcurrency: 2, SerialThreshold: 0}, filters...)
+	chain.pos++ // signal chain that it's processing first filter
+	var parallelFilter, ok = chain.filters[0].(*parallelFilter)
+	assert.True(t, ok)
+	var err = parallelFilter.Execute(context.Background(), chain, nil)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, int(atomic.LoadInt32(&peak)), 2)
+}
+
+func TestSubscribePublishesStartedAndCompletedForNamedFilter(t *testing.T) {
+	var chain, store = New(nil)
+	var filter1 = NewNamedFilter("greet", func(chain *Chain, store *Store) error {
+		return nil
+	})
+
+	chain.AddFilters(filter1)
+	var started, cancelStarted = chain.Subscribe(FilterStarted)
+	defer cancelStarted()
+	var completed, cancelCompleted = chain.Subscribe(FilterCompleted)
+	defer cancelCompleted()
+
+	var err = chain.Execute(store)
+	assert.NoError(t, err)
+
+	var startedEvent = <-started
+	assert.Equal(t, "greet", startedEvent.Name)
+	assert.Equal(t, 0, startedEvent.Index)
+
+	var completedEvent = <-completed
+	assert.Equal(t, "greet", completedEvent.Name)
+}
+
+func TestSubscribeAfterCancelReceivesNothing(t *testing.T) {
+	var chain, store = New(nil)
+	var filter1 = NewFilter(func(chain *Chain, store *Store) error {
+		return errors.New("filter execution failed")
+	})
+
+	chain.AddFilters(filter1)
+	var errored, cancel = chain.Subscribe(FilterErrored)
+	cancel()
+
+	var err = chain.Execute(store)
+	assert.EqualError(t, err, "filter execution failed")
+
+	select {
+	case event := <-errored:
+		t.Fatalf("expected no event after cancel, got %+v", event)
+	default:
+	}
+}
+
+func TestSubscribeAttributesEventsToTheFilterThatOwnsThem(t *testing.T) {
+	var chain, store = New(nil)
+	var first = NewNamedFilter("first", func(chain *Chain, store *Store) error {
+		return chain.Next(store)
+	})
+	var second = NewNamedFilter("second", func(chain *Chain, store *Store) error {
+		time.Sleep(20 * time.Millisecond)
+		return errors.New("downstream failure")
+	})
+
+	chain.AddFilters(first, second)
+	var completed, cancelCompleted = chain.Subscribe(FilterCompleted)
+	defer cancelCompleted()
+	var errored, cancelErrored = chain.Subscribe(FilterErrored)
+	defer cancelErrored()
+
+	var err = chain.Execute(store)
+	assert.EqualError(t, err, "downstream failure")
+
+	var completedEvent = <-completed
+	assert.Equal(t, "first", completedEvent.Name)
+	assert.Less(t, completedEvent.Duration, 20*time.Millisecond)
+
+	var erroredEvent = <-errored
+	assert.Equal(t, "second", erroredEvent.Name)
+
+	select {
+	case event := <-errored:
+		t.Fatalf("expected only one FilterErrored event, got a second one for %q", event.Name)
+	default:
+	}
+}
+
+func TestRollbackRunsInLIFOOrderOnFailure(t *testing.T) {
+	var chain, store = New(nil)
+	var order []int
+	var filter1 = NewFilter(func(chain *Chain, store *Store) error {
+		chain.OnRollback(func(store *Store) error {
+			order = append(order, 1)
+			return nil
+		})
+		return chain.Next(store)
+	})
+	var filter2 = NewFilter(func(chain *Chain, store *Store) error {
+		chain.OnRollback(func(store *Store) error {
+			order = append(order, 2)
+			return nil
+		})
+		return errors.New("filter execution failed")
+	})
+
+	chain.AddFilters(filter1, filter2)
+	var err = chain.Execute(store)
+	assert.EqualError(t, err, "filter execution failed")
+	assert.Equal(t, []int{2, 1}, order)
+}
+
+func TestRollbackErrorsAreJoinedWithOriginalError(t *testing.T) {
+	var chain, store = New(nil)
+	var filter1 = NewFilter(func(chain *Chain, store *Store) error {
+		chain.OnRollback(func(store *Store) error {
+			return errors.New("rollback failed")
+		})
+		return errors.New("filter execution failed")
+	})
+
+	chain.AddFilters(filter1)
+	var err = chain.Execute(store)
+	assert.ErrorContains(t, err, "filter execution failed")
+	assert.ErrorContains(t, err, "rollback failed")
+}
+
 func TestParallelFilterError(t *testing.T) {
 	var chain, _ = New(nil)
 	var filter1 = NewFilter(func(chain *Chain, store *Store) error {
@@ -193,6 +479,6 @@ func TestParallelFilterError(t *testing.T) {
 	chain.pos++ // signal chain that it's processing first filter
 	var parallelFilter, ok = chain.filters[0].(*parallelFilter)
 	assert.True(t, ok)
-	var err = parallelFilter.Execute(chain, nil)
+	var err = parallelFilter.Execute(context.Background(), chain, nil)
 	assert.EqualError(t, err, "filter execution failed")
 }
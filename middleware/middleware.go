@@ -0,0 +1,229 @@
+// Package middleware provides Executer combinators for orchestrating flaky
+// I/O work (HTTP calls, DB writes) on top of filterchain, instead of every
+// caller hand-rolling retry/timeout/circuit-breaker logic in each filter.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	filterchain "github.com/vivek-26/go-filterchain"
+)
+
+// RetryPolicy configures WithRetry's exponential backoff.
+type RetryPolicy struct {
+	// MaxAttempts caps how many times f is run, including the first try.
+	MaxAttempts int
+	// BaseDelay is the delay before the second attempt; it doubles on each
+	// attempt after that. Defaults to 100ms if unset.
+	BaseDelay time.Duration
+	// MaxDelay caps the computed delay between attempts. Zero means
+	// uncapped.
+	MaxDelay time.Duration
+}
+
+// retrier wraps an Executer with exponential backoff and jitter.
+type retrier struct {
+	filter filterchain.Executer
+	policy RetryPolicy
+}
+
+func (r *retrier) Execute(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+	var attempts = r.policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff(r.policy, attempt)):
+			}
+		}
+
+		if err = r.filter.Execute(ctx, chain, store); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// maxBackoffDelay is a hard ceiling on the computed delay, independent of
+// policy.MaxDelay, so that a large MaxAttempts with MaxDelay left at its
+// zero value (uncapped) can't double the delay past time.Duration's range
+// and wrap negative.
+const maxBackoffDelay = 24 * time.Hour
+
+// backoff returns the exponential delay with jitter for attempt (1-indexed),
+// capped at policy.MaxDelay.
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	var base = policy.BaseDelay
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+
+	var delay = base
+	for i := 1; i < attempt && delay < maxBackoffDelay; i++ {
+		delay *= 2
+		if delay <= 0 {
+			delay = maxBackoffDelay
+			break
+		}
+	}
+	if delay > maxBackoffDelay {
+		delay = maxBackoffDelay
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2+1)))
+}
+
+// WithRetry wraps f so it is run up to policy.MaxAttempts times in all
+// (at least once), with exponential backoff and jitter between attempts,
+// before its last error is returned.
+func WithRetry(f filterchain.Executer, policy RetryPolicy) filterchain.Executer {
+	return &retrier{filter: f, policy: policy}
+}
+
+// timeoutFilter wraps an Executer with a deadline enforced from a separate
+// goroutine, since f itself may not observe ctx cancellation.
+type timeoutFilter struct {
+	filter filterchain.Executer
+	d      time.Duration
+}
+
+func (t *timeoutFilter) Execute(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+	var timeoutCtx, cancel = context.WithTimeout(ctx, t.d)
+	defer cancel()
+
+	var done = make(chan error, 1)
+	go func() {
+		done <- t.filter.Execute(timeoutCtx, chain, store)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timeoutCtx.Done():
+		return timeoutCtx.Err()
+	}
+}
+
+// WithTimeout wraps f so it is run in its own goroutine and aborted with
+// context.DeadlineExceeded if it has not completed within d. f keeps
+// running in the background after the timeout fires; it is f's
+// responsibility to observe ctx and return promptly.
+func WithTimeout(f filterchain.Executer, d time.Duration) filterchain.Executer {
+	return &timeoutFilter{filter: f, d: d}
+}
+
+// ErrCircuitOpen is returned by a filter wrapped with WithCircuitBreaker
+// while its circuit is open.
+var ErrCircuitOpen = errors.New("middleware: circuit open")
+
+// BreakerConfig configures WithCircuitBreaker.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures, within
+	// Window, that trips the breaker open.
+	FailureThreshold int
+	// Window bounds how long a failure streak is tracked over; a gap since
+	// the last failure longer than Window resets the streak. Zero means
+	// the streak never expires on its own.
+	Window time.Duration
+	// HalfOpenAfter is how long the breaker stays open before letting a
+	// single probe request through.
+	HalfOpenAfter time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker wraps an Executer with a circuit breaker. Its counters are
+// guarded by a mutex so the same wrapped filter can be added to a
+// parallelFilter group.
+type breaker struct {
+	filter filterchain.Executer
+	cfg    BreakerConfig
+
+	// guards state, failures, lastFailureAt, openedAt
+	mu            sync.Mutex
+	state         breakerState
+	failures      int
+	lastFailureAt time.Time
+	openedAt      time.Time
+}
+
+func (b *breaker) Execute(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+
+	var err = b.filter.Execute(ctx, chain, store)
+	b.record(err)
+	return err
+}
+
+// allow reports whether a call should be let through. An open breaker
+// admits exactly one caller as its half-open probe once HalfOpenAfter has
+// elapsed; every other caller is denied until record resolves that probe
+// one way or the other.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		return false
+	default: // breakerOpen
+		if time.Since(b.openedAt) < b.cfg.HalfOpenAfter {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	}
+}
+
+// record updates the breaker's state following a call's result.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	if b.cfg.Window > 0 && !b.lastFailureAt.IsZero() && time.Since(b.lastFailureAt) > b.cfg.Window {
+		b.failures = 0
+	}
+	b.failures++
+	b.lastFailureAt = time.Now()
+
+	if b.state == breakerHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// WithCircuitBreaker wraps f with a circuit breaker that trips open after
+// cfg.FailureThreshold consecutive failures within cfg.Window, short-
+// circuiting with ErrCircuitOpen until a half-open probe through f
+// succeeds.
+func WithCircuitBreaker(f filterchain.Executer, cfg BreakerConfig) filterchain.Executer {
+	return &breaker{filter: f, cfg: cfg}
+}
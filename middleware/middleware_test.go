@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	filterchain "github.com/vivek-26/go-filterchain"
+	"github.com/stretchr/testify/assert"
+)
+
+type testExecuter func(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error
+
+func (f testExecuter) Execute(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+	return f(ctx, chain, store)
+}
+
+func TestWithRetrySucceedsAfterFailures(t *testing.T) {
+	var attempts int
+	var filter = testExecuter(func(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("transient failure")
+		}
+		return nil
+	})
+
+	var wrapped = WithRetry(filter, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond})
+	var err = wrapped.Execute(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestWithRetryReturnsLastErrorAfterMaxAttempts(t *testing.T) {
+	var filter = testExecuter(func(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+		return errors.New("persistent failure")
+	})
+
+	var wrapped = WithRetry(filter, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	var err = wrapped.Execute(context.Background(), nil, nil)
+	assert.EqualError(t, err, "persistent failure")
+}
+
+func TestBackoffDoesNotOverflowWithManyAttemptsAndNoMaxDelay(t *testing.T) {
+	var policy = RetryPolicy{MaxAttempts: 40, BaseDelay: 100 * time.Millisecond}
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		var delay = backoff(policy, attempt)
+		assert.GreaterOrEqual(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, maxBackoffDelay)
+	}
+}
+
+func TestWithTimeoutReturnsDeadlineExceeded(t *testing.T) {
+	var filter = testExecuter(func(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	var wrapped = WithTimeout(filter, 10*time.Millisecond)
+	var err = wrapped.Execute(context.Background(), nil, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWithCircuitBreakerTripsAfterConsecutiveFailures(t *testing.T) {
+	var filter = testExecuter(func(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+		return errors.New("downstream failure")
+	})
+
+	var wrapped = WithCircuitBreaker(filter, BreakerConfig{FailureThreshold: 2, HalfOpenAfter: time.Hour})
+
+	var err = wrapped.Execute(context.Background(), nil, nil)
+	assert.EqualError(t, err, "downstream failure")
+
+	err = wrapped.Execute(context.Background(), nil, nil)
+	assert.EqualError(t, err, "downstream failure")
+
+	err = wrapped.Execute(context.Background(), nil, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
+
+func TestWithCircuitBreakerRecoversAfterHalfOpenProbeSucceeds(t *testing.T) {
+	var shouldFail = true
+	var filter = testExecuter(func(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+		if shouldFail {
+			return errors.New("downstream failure")
+		}
+		return nil
+	})
+
+	var wrapped = WithCircuitBreaker(filter, BreakerConfig{FailureThreshold: 1, HalfOpenAfter: time.Millisecond})
+
+	var err = wrapped.Execute(context.Background(), nil, nil)
+	assert.EqualError(t, err, "downstream failure")
+
+	err = wrapped.Execute(context.Background(), nil, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	time.Sleep(5 * time.Millisecond)
+	shouldFail = false
+	err = wrapped.Execute(context.Background(), nil, nil)
+	assert.NoError(t, err)
+}
+
+func TestWithCircuitBreakerAdmitsOnlyOneHalfOpenProbeConcurrently(t *testing.T) {
+	var running, maxSeen int32
+	var shouldFail int32 = 1
+	var filter = testExecuter(func(ctx context.Context, chain *filterchain.Chain, store *filterchain.Store) error {
+		var n = atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			var seen = atomic.LoadInt32(&maxSeen)
+			if n <= seen || atomic.CompareAndSwapInt32(&maxSeen, seen, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if atomic.LoadInt32(&shouldFail) == 1 {
+			return errors.New("downstream failure")
+		}
+		return nil
+	})
+
+	var wrapped = WithCircuitBreaker(filter, BreakerConfig{FailureThreshold: 1, HalfOpenAfter: time.Millisecond})
+
+	var err = wrapped.Execute(context.Background(), nil, nil)
+	assert.EqualError(t, err, "downstream failure")
+
+	time.Sleep(5 * time.Millisecond)
+	atomic.StoreInt32(&shouldFail, 0)
+
+	var wg sync.WaitGroup
+	var openCount int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := wrapped.Execute(context.Background(), nil, nil); errors.Is(err, ErrCircuitOpen) {
+				atomic.AddInt32(&openCount, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&maxSeen))
+	assert.EqualValues(t, 4, atomic.LoadInt32(&openCount))
+}